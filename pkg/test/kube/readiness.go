@@ -0,0 +1,85 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"istio.io/pkg/log"
+)
+
+// Condition reports whether a watched resource has reached the desired state. It is polled by a
+// Waiter until it returns true, returns an error, or the Waiter's timeout elapses.
+type Condition func() (bool, error)
+
+// Waiter replaces the hand-rolled "for attempts := 1; attempts <= maxAttempts" sleep loops that
+// used to be scattered across the e2e framework with a single, reusable polling primitive. Use it
+// for conditions that don't map onto watching a single Kubernetes object's state (e.g. AppSpec's
+// readiness probe callbacks); waits that do
+// watch a GVR/namespace/name directly should use the informer-backed ReadinessWaiter instead.
+type Waiter struct {
+	// Interval is how long to wait between polls of a Condition.
+	Interval time.Duration
+	// Timeout bounds the total time spent waiting for a single Condition.
+	Timeout time.Duration
+}
+
+// NewWaiter creates a Waiter that polls every interval, giving up after timeout.
+func NewWaiter(interval, timeout time.Duration) *Waiter {
+	return &Waiter{Interval: interval, Timeout: timeout}
+}
+
+// WaitFor blocks until cond reports ready, cond returns an error, ctx is cancelled, or the
+// Waiter's Timeout elapses. name is used only in the returned error, to identify which condition
+// failed or timed out.
+func (w *Waiter) WaitFor(ctx context.Context, name string, cond Condition) error {
+	deadline := time.Now().Add(w.Timeout)
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		ready, err := cond()
+		if err != nil {
+			return fmt.Errorf("error while waiting for %s: %v", name, err)
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", w.Timeout, name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled while waiting for %s: %v", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForAll waits for every named Condition in conds one at a time, in map iteration order (which
+// is not defined), logging each as it completes. It returns the first error encountered; the
+// conditions not yet reached are not waited for.
+func (w *Waiter) WaitForAll(ctx context.Context, conds map[string]Condition) error {
+	for name, cond := range conds {
+		if err := w.WaitFor(ctx, name, cond); err != nil {
+			return err
+		}
+		log.Infof("%s is ready", name)
+	}
+	return nil
+}
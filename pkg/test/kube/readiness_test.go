@@ -0,0 +1,111 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaiterWaitForReady(t *testing.T) {
+	w := NewWaiter(time.Millisecond, time.Second)
+	calls := 0
+	err := w.WaitFor(context.Background(), "immediate", func() (bool, error) {
+		calls++
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("WaitFor returned error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected cond to be called once, got %d", calls)
+	}
+}
+
+func TestWaiterWaitForEventuallyReady(t *testing.T) {
+	w := NewWaiter(time.Millisecond, time.Second)
+	calls := 0
+	err := w.WaitFor(context.Background(), "eventual", func() (bool, error) {
+		calls++
+		return calls >= 3, nil
+	})
+	if err != nil {
+		t.Fatalf("WaitFor returned error: %v", err)
+	}
+	if calls < 3 {
+		t.Fatalf("expected cond to be polled at least 3 times, got %d", calls)
+	}
+}
+
+func TestWaiterWaitForCondError(t *testing.T) {
+	w := NewWaiter(time.Millisecond, time.Second)
+	wantErr := errors.New("boom")
+	err := w.WaitFor(context.Background(), "erroring", func() (bool, error) {
+		return false, wantErr
+	})
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestWaiterWaitForTimeout(t *testing.T) {
+	w := NewWaiter(time.Millisecond, 10*time.Millisecond)
+	err := w.WaitFor(context.Background(), "never", func() (bool, error) {
+		return false, nil
+	})
+	if err == nil {
+		t.Fatal("expected a timeout error, got nil")
+	}
+}
+
+func TestWaiterWaitForContextCancelled(t *testing.T) {
+	w := NewWaiter(time.Second, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := w.WaitFor(ctx, "cancelled", func() (bool, error) {
+		return false, nil
+	})
+	if err == nil {
+		t.Fatal("expected a context-cancelled error, got nil")
+	}
+}
+
+func TestWaiterWaitForAll(t *testing.T) {
+	w := NewWaiter(time.Millisecond, time.Second)
+	var order []string
+	conds := map[string]Condition{
+		"a": func() (bool, error) { order = append(order, "a"); return true, nil },
+		"b": func() (bool, error) { order = append(order, "b"); return true, nil },
+	}
+	if err := w.WaitForAll(context.Background(), conds); err != nil {
+		t.Fatalf("WaitForAll returned error: %v", err)
+	}
+	if len(order) != len(conds) {
+		t.Fatalf("expected every condition to be evaluated, got %v", order)
+	}
+}
+
+func TestWaiterWaitForAllStopsOnFirstError(t *testing.T) {
+	w := NewWaiter(time.Millisecond, time.Second)
+	wantErr := errors.New("boom")
+	conds := map[string]Condition{
+		"failing": func() (bool, error) { return false, wantErr },
+	}
+	if err := w.WaitForAll(context.Background(), conds); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
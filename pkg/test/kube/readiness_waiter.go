@@ -0,0 +1,218 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"istio.io/pkg/log"
+)
+
+// ReadinessCondition reports whether obj, the current informer-cached state of a watched
+// resource, satisfies the condition a ReadinessWaiter caller is blocked on.
+type ReadinessCondition func(obj *unstructured.Unstructured) (bool, error)
+
+// ReadinessListCondition is like ReadinessCondition but evaluated over every object of a GVR
+// observed in a namespace, for waits that aren't pinned to a single named object (e.g. "at least
+// one pod matching this label exists").
+type ReadinessListCondition func(objs []*unstructured.Unstructured) (bool, error)
+
+// ReadinessWaiter replaces the hand-rolled "kubectl get X | grep Y" and "shell out once a second"
+// poll loops scattered across the e2e framework with a single primitive backed by client-go
+// informers: every distinct cluster gets one shared informer factory, so concurrent WaitFor calls
+// against the same cluster share a single watch instead of spawning a shell process per poll.
+type ReadinessWaiter struct {
+	mu        sync.Mutex
+	factories map[string]dynamicinformer.DynamicSharedInformerFactory
+	informers map[string]map[schema.GroupVersionResource]cache.SharedIndexInformer
+	stopCh    chan struct{}
+}
+
+// NewReadinessWaiter creates an empty ReadinessWaiter. Informer factories are created lazily, one
+// per distinct kubeConfig path passed to WaitFor/WaitForAny.
+func NewReadinessWaiter() *ReadinessWaiter {
+	return &ReadinessWaiter{
+		factories: map[string]dynamicinformer.DynamicSharedInformerFactory{},
+		informers: map[string]map[schema.GroupVersionResource]cache.SharedIndexInformer{},
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// WaitFor blocks until the object identified by gvr/namespace/name satisfies cond, ctx is
+// cancelled, or timeout elapses. On timeout the returned error includes the last state the
+// informer observed for the object (or notes it was never observed at all), rather than the
+// opaque "timeout waiting for X" the shell-based poll loops used to return.
+func (w *ReadinessWaiter) WaitFor(ctx context.Context, kubeConfig string, gvr schema.GroupVersionResource,
+	namespace, name string, timeout time.Duration, cond ReadinessCondition) error {
+	informer, err := w.informerFor(kubeConfig, gvr)
+	if err != nil {
+		return err
+	}
+
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+
+	var last *unstructured.Unstructured
+	check := func() (bool, error) {
+		obj, exists, err := informer.GetIndexer().GetByKey(key)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			last = nil
+			return false, nil
+		}
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return false, fmt.Errorf("unexpected object type %T for %s %s", obj, gvr, key)
+		}
+		last = u
+		return cond(u)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := wait.PollImmediateUntil(time.Second, check, timeoutCtx.Done()); err != nil {
+		if last == nil {
+			return fmt.Errorf("timed out waiting for %s %s: object was never observed", gvr, key)
+		}
+		return fmt.Errorf("timed out waiting for %s %s: last observed state: %v", gvr, key, last.Object)
+	}
+	return nil
+}
+
+// WaitForAbsence blocks until the object identified by gvr/namespace/name is no longer observed,
+// ctx is cancelled, or timeout elapses. It is the mirror image of WaitFor: WaitFor's cond is never
+// invoked while the object is absent, so deletion waits (a namespace or webhook configuration
+// being torn down) need this instead.
+func (w *ReadinessWaiter) WaitForAbsence(ctx context.Context, kubeConfig string, gvr schema.GroupVersionResource,
+	namespace, name string, timeout time.Duration) error {
+	informer, err := w.informerFor(kubeConfig, gvr)
+	if err != nil {
+		return err
+	}
+
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+
+	check := func() (bool, error) {
+		_, exists, err := informer.GetIndexer().GetByKey(key)
+		if err != nil {
+			return false, err
+		}
+		return !exists, nil
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := wait.PollImmediateUntil(time.Second, check, timeoutCtx.Done()); err != nil {
+		return fmt.Errorf("timed out waiting for %s %s to be deleted", gvr, key)
+	}
+	return nil
+}
+
+// WaitForAny is like WaitFor but for waits that aren't pinned to a single named object, e.g.
+// "at least one pod with this label exists in this namespace". cond sees every object of gvr
+// currently cached for namespace (all namespaces, if namespace is empty).
+func (w *ReadinessWaiter) WaitForAny(ctx context.Context, kubeConfig string, gvr schema.GroupVersionResource,
+	namespace string, timeout time.Duration, cond ReadinessListCondition) error {
+	informer, err := w.informerFor(kubeConfig, gvr)
+	if err != nil {
+		return err
+	}
+
+	var last []*unstructured.Unstructured
+	check := func() (bool, error) {
+		var raw []interface{}
+		var err error
+		if namespace == "" {
+			raw = informer.GetIndexer().List()
+		} else if raw, err = informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace); err != nil {
+			return false, err
+		}
+
+		objs := make([]*unstructured.Unstructured, 0, len(raw))
+		for _, o := range raw {
+			if u, ok := o.(*unstructured.Unstructured); ok {
+				objs = append(objs, u)
+			}
+		}
+		last = objs
+		return cond(objs)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := wait.PollImmediateUntil(time.Second, check, timeoutCtx.Done()); err != nil {
+		return fmt.Errorf("timed out waiting for %s in namespace %q: last observed %d object(s)", gvr, namespace, len(last))
+	}
+	return nil
+}
+
+// informerFor returns the shared informer for gvr on the cluster reached via kubeConfig,
+// creating (and starting) its cluster's informer factory on first use.
+func (w *ReadinessWaiter) informerFor(kubeConfig string, gvr schema.GroupVersionResource) (cache.SharedIndexInformer, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.informers[kubeConfig]; !ok {
+		w.informers[kubeConfig] = map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+	}
+	if informer, ok := w.informers[kubeConfig][gvr]; ok {
+		return informer, nil
+	}
+
+	factory, ok := w.factories[kubeConfig]
+	if !ok {
+		restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %v", kubeConfig, err)
+		}
+		client, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client for %s: %v", kubeConfig, err)
+		}
+		factory = dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+		w.factories[kubeConfig] = factory
+	}
+
+	informer := factory.ForResource(gvr).Informer()
+	w.informers[kubeConfig][gvr] = informer
+	factory.Start(w.stopCh)
+	if !cache.WaitForCacheSync(w.stopCh, informer.HasSynced) {
+		return nil, fmt.Errorf("failed to sync informer cache for %s against %s", gvr, kubeConfig)
+	}
+
+	log.Debugf("started shared informer for %s against %s", gvr, kubeConfig)
+	return informer, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var podsGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+
+// badKubeConfig points at a file that can't possibly be a valid kubeconfig, so
+// clientcmd.BuildConfigFromFlags fails deterministically without needing a live cluster.
+func badKubeConfig(t *testing.T) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "does-not-exist.yaml")
+}
+
+func TestReadinessWaiterWaitForBadKubeConfig(t *testing.T) {
+	w := NewReadinessWaiter()
+	err := w.WaitFor(context.Background(), badKubeConfig(t), podsGVR, "ns", "name", time.Second,
+		func(*unstructured.Unstructured) (bool, error) { return true, nil })
+	if err == nil {
+		t.Fatal("expected an error for an unreadable kubeconfig, got nil")
+	}
+}
+
+func TestReadinessWaiterWaitForAnyBadKubeConfig(t *testing.T) {
+	w := NewReadinessWaiter()
+	err := w.WaitForAny(context.Background(), badKubeConfig(t), podsGVR, "ns", time.Second,
+		func([]*unstructured.Unstructured) (bool, error) { return true, nil })
+	if err == nil {
+		t.Fatal("expected an error for an unreadable kubeconfig, got nil")
+	}
+}
+
+func TestReadinessWaiterWaitForAbsenceBadKubeConfig(t *testing.T) {
+	w := NewReadinessWaiter()
+	err := w.WaitForAbsence(context.Background(), badKubeConfig(t), podsGVR, "ns", "name", time.Second)
+	if err == nil {
+		t.Fatal("expected an error for an unreadable kubeconfig, got nil")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/istio/tests/util"
+	"istio.io/pkg/log"
+)
+
+const (
+	// ztunnelChartName is the name of the helm chart/release that installs the ztunnel DaemonSet.
+	ztunnelChartName = "ztunnel"
+	// ztunnelPodLabel selects ztunnel's DaemonSet pods, mirroring the "k8s-app" convention the
+	// istio-cni DaemonSet already uses.
+	ztunnelPodLabel = "k8s-app=ztunnel"
+	// ztunnelContainerName is the container GetRoutes execs into on a ztunnel pod.
+	ztunnelContainerName = "ztunnel"
+	// maxZtunnelDeployTime bounds how long checkDeployments waits for every node's ztunnel pod to
+	// report Ready.
+	maxZtunnelDeployTime = 180 * time.Second
+)
+
+var (
+	ztunnelHelmRepo = flag.String("ztunnel_helm_repo", "istio.io/ztunnel", "Name of the ztunnel node proxy helm repo")
+	ztunnelHub      = flag.String("ztunnel_hub", os.Getenv("HUB"), "ztunnel node proxy hub")
+	ztunnelTag      = flag.String("ztunnel_tag", os.Getenv("TAG"), "ztunnel node proxy tag")
+)
+
+// deployZtunnel installs the per-node ztunnel proxy DaemonSet that backs ambient mode's data
+// plane, following the same helm-fetch/template/apply path deployCNI uses for the CNI DaemonSet.
+func (k *KubeInfo) deployZtunnel() error {
+	log.Info("Deploy ztunnel node proxy components")
+	setValue := " --set-string hub=" + *ztunnelHub + " --set-string tag=" + *ztunnelTag
+	if *installer == helmInstallerName {
+		if err := util.HelmInstall(*ztunnelHelmRepo, ztunnelChartName, "", k.Namespace, setValue); err != nil {
+			log.Errorf("Helm install ztunnel chart failed, setValue=%s, namespace=%s", setValue, k.Namespace)
+			return err
+		}
+		return nil
+	}
+
+	chartDir := filepath.Join(k.TmpDir, "ztunnelChartDir")
+	if err := util.HelmFetch(*ztunnelHelmRepo, chartDir); err != nil {
+		log.Errorf("Helm fetch of %s failed", *ztunnelHelmRepo)
+		return err
+	}
+	outputFile := filepath.Join(k.TmpDir, "ztunnel_install.yaml")
+	chartDir = filepath.Join(chartDir, ztunnelChartName)
+	if err := util.HelmTemplate(chartDir, ztunnelChartName, k.Namespace, setValue, outputFile); err != nil {
+		log.Errorf("Helm template of ztunnel failed")
+		return err
+	}
+	if err := util.KubeApply(k.Namespace, outputFile, k.KubeConfig); err != nil {
+		log.Errorf("Kubeapply ztunnel failed")
+		return err
+	}
+	return nil
+}
+
+// waitForZtunnelReady blocks until as many ztunnel pods report a Ready condition as the cluster
+// reached via kubeConfig has nodes.
+func (k *KubeInfo) waitForZtunnelReady(kubeConfig string) error {
+	nodeCount, err := util.GetNodeCount(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to count nodes for ztunnel readiness check: %v", err)
+	}
+
+	return k.waiter().WaitForAny(context.Background(), kubeConfig, podsGVR, k.Namespace, maxZtunnelDeployTime,
+		func(pods []*unstructured.Unstructured) (bool, error) {
+			ready := 0
+			for _, pod := range pods {
+				if pod.GetLabels()["k8s-app"] != "ztunnel" {
+					continue
+				}
+				conditions, _, err := unstructured.NestedSlice(pod.Object, "status", "conditions")
+				if err != nil {
+					return false, err
+				}
+				for _, c := range conditions {
+					if condition, ok := c.(map[string]interface{}); ok && condition["type"] == "Ready" && condition["status"] == "True" {
+						ready++
+						break
+					}
+				}
+			}
+			return ready >= nodeCount, nil
+		})
+}
+
+// ztunnelPodForAppPod returns the name of the ztunnel pod running on the same node as appPod, so
+// GetRoutes can exec into the per-node proxy instead of the app pod's own localhost:15000 admin
+// port, which ambient mode's sidecar-less pods no longer serve.
+func (k *KubeInfo) ztunnelPodForAppPod(cluster, appPod string) (string, error) {
+	c := k.clusterByName(cluster)
+
+	node, err := util.GetPodNode(k.Namespace, appPod, c.KubeConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to find node for pod %s: %v", appPod, err)
+	}
+
+	pod, err := util.GetPodNameOnNode(k.Namespace, ztunnelPodLabel, node, c.KubeConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to find ztunnel proxy on node %s: %v", node, err)
+	}
+	return pod, nil
+}
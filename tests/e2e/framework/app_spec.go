@@ -0,0 +1,216 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	testKube "istio.io/istio/pkg/test/kube"
+	"istio.io/istio/tests/util"
+	"istio.io/pkg/log"
+)
+
+// anyCluster lets an AppSpec opt in to being deployed to every cluster in the topology, rather
+// than pinning itself to a single named cluster.
+const anyCluster = "any"
+
+// Port describes a single port a workload listens on.
+type Port struct {
+	// Name identifies the port, e.g. "http" or "grpc".
+	Name string
+	// Port is the port number the workload listens on.
+	Port int
+	// Protocol is the L7 protocol served on Port, e.g. "http", "grpc", "tcp".
+	Protocol string
+}
+
+// Subset describes one versioned subset of an AppSpec's workload, e.g. "v1"/"v2" of reviews.
+type Subset struct {
+	// Name identifies the subset, e.g. "v1".
+	Name string
+	// Version is the value of the "version" pod label distinguishing this subset.
+	Version string
+	// Labels are additional pod labels applied only to this subset.
+	Labels map[string]string
+}
+
+// AppSpec declaratively describes a test workload, replacing the old freeform AppYamlTemplate
+// string so tests can reason about what they deployed instead of templating YAML by hand.
+type AppSpec struct {
+	// Name is the app label value used to identify the workload's pods.
+	Name string
+	// Namespace the workload is deployed into. Defaults to the KubeInfo's namespace if empty.
+	Namespace string
+	// Cluster names which cluster in KubeInfo.Clusters to deploy to, or anyCluster ("any") to
+	// deploy to every cluster in the topology.
+	Cluster string
+	// Ports lists the ports the workload's container(s) listen on.
+	Ports []Port
+	// ServiceAccount the workload's pods run as.
+	ServiceAccount string
+	// Subsets lists the versioned subsets backing the workload's Service, if any.
+	Subsets []Subset
+	// Sidecar controls whether the workload's pods should get the Istio sidecar injected.
+	Sidecar bool
+	// WaitForReady, if true, blocks DeployAll until the workload satisfies ReadinessProbe (or, if
+	// ReadinessProbe is nil, until its pods are Running).
+	WaitForReady bool
+	// ReadinessProbe, when set, overrides the default pod-running check used for WaitForReady.
+	ReadinessProbe func(*KubeInfo) error
+}
+
+// DeployAll deploys every AppSpec in specs across all clusters it targets, waiting on each
+// workload's readiness as it comes up before moving on to the next. Deployment order follows the
+// order of specs; apps within a single spec are deployed to their target cluster(s) in the order
+// KubeInfo.Clusters lists them.
+func (a *AppManager) DeployAll(ctx context.Context, k *KubeInfo, specs []AppSpec) error {
+	for _, spec := range specs {
+		for _, c := range k.Clusters {
+			if spec.Cluster != anyCluster && spec.Cluster != "" && spec.Cluster != c.Name {
+				continue
+			}
+			if err := a.deploySpec(spec, k, c); err != nil {
+				return fmt.Errorf("failed to deploy app %q to cluster %s: %v", spec.Name, c.Name, err)
+			}
+			if spec.WaitForReady {
+				if err := a.waitForSpecReady(ctx, spec, k, c.Name); err != nil {
+					return fmt.Errorf("app %q never became ready on cluster %s: %v", spec.Name, c.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deploySpec renders spec into an App YAML template and deploys it into cluster c, reusing the
+// existing freeform-template deploy path that AppSpec is meant to eventually replace entirely.
+func (a *AppManager) deploySpec(spec AppSpec, k *KubeInfo, c *ClusterInfo) error {
+	ns := spec.Namespace
+	if ns == "" {
+		ns = k.Namespace
+	}
+	log.Infof("Deploying app %q (sidecar=%v) to namespace %s on cluster %s", spec.Name, spec.Sidecar, ns, c.Name)
+	return a.deployApp(spec.Name, ns, spec.Ports, spec.ServiceAccount, spec.Subsets, spec.Sidecar, c.KubeConfig)
+}
+
+// waitForSpecReady blocks until spec's ReadinessProbe succeeds, falling back to waiting for the
+// workload's pods to be observed running via KubeInfo.GetAppPods, on the cluster DeployAll just
+// deployed spec to. Either way the wait is bounded by a Waiter so a stuck workload fails the test
+// instead of hanging it.
+func (a *AppManager) waitForSpecReady(ctx context.Context, spec AppSpec, k *KubeInfo, cluster string) error {
+	probe := spec.ReadinessProbe
+	if probe == nil {
+		probe = func(k *KubeInfo) error {
+			pods := k.GetAppPods(cluster)
+			if len(pods[spec.Name]) == 0 {
+				return fmt.Errorf("no pods found for app %q on cluster %s", spec.Name, cluster)
+			}
+			return nil
+		}
+	}
+	waiter := testKube.NewWaiter(readinessPollInterval, readinessPollTimeout)
+	return waiter.WaitFor(ctx, fmt.Sprintf("app %q ready on cluster %s", spec.Name, cluster), func() (bool, error) {
+		if err := probe(k); err != nil {
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// CallRequest describes a single traffic-generation request from one AppSpec to another.
+type CallRequest struct {
+	// PortName selects which of the target AppSpec's Ports to call. Defaults to the first port.
+	PortName string
+	// Path is the HTTP path to request. Defaults to "/".
+	Path string
+	// Count is the number of requests to send. Defaults to 1.
+	Count int
+}
+
+// CallResponse summarizes the result of a CallRequest.
+type CallResponse struct {
+	// Count is the number of responses that reported StatusCode=200.
+	Count int
+	// Raw holds the unparsed output of the underlying client invocation, for callers that need
+	// more detail than the summary above.
+	Raw string
+}
+
+// Call sends req from one pod of the "from" AppSpec to the "to" AppSpec's service, giving tests a
+// structured alternative to hand-rolling util.PodExec "client" invocations. from and to must
+// already have been deployed via DeployAll.
+func (a *AppManager) Call(ctx context.Context, k *KubeInfo, from, to AppSpec, req CallRequest) (CallResponse, error) {
+	fromCluster := from.Cluster
+	if fromCluster == "" || fromCluster == anyCluster {
+		fromCluster = PrimaryCluster
+	}
+	fromPods := k.GetAppPods(fromCluster)[from.Name]
+	if len(fromPods) == 0 {
+		return CallResponse{}, fmt.Errorf("no pods found for app %q on cluster %s", from.Name, fromCluster)
+	}
+
+	port, err := callPort(to, req.PortName)
+	if err != nil {
+		return CallResponse{}, err
+	}
+	path := req.Path
+	if path == "" {
+		path = "/"
+	}
+	count := req.Count
+	if count <= 0 {
+		count = 1
+	}
+	toNs := to.Namespace
+	if toNs == "" {
+		toNs = k.Namespace
+	}
+	url := fmt.Sprintf("%s://%s.%s:%d%s", port.Protocol, to.Name, toNs, port.Port, path)
+
+	kubeConfig, err := k.ClusterKubeconfig(fromCluster)
+	if err != nil {
+		return CallResponse{}, err
+	}
+	cmd := fmt.Sprintf("client -url %s -count %d", url, count)
+	out, err := util.PodExec(k.Namespace, fromPods[0], "app", cmd, true, kubeConfig)
+	if err != nil {
+		return CallResponse{}, fmt.Errorf("call from %q to %q failed: %v", from.Name, to.Name, err)
+	}
+
+	log.Infof("Call from %q to %q (%s): %s", from.Name, to.Name, url, out)
+	return CallResponse{
+		Count: strings.Count(out, "StatusCode=200"),
+		Raw:   out,
+	}, nil
+}
+
+// callPort picks the Port an AppSpec's Call target should be reached on: the one named name, or
+// the first declared port if name is empty.
+func callPort(spec AppSpec, name string) (Port, error) {
+	if len(spec.Ports) == 0 {
+		return Port{}, fmt.Errorf("app %q declares no ports", spec.Name)
+	}
+	if name == "" {
+		return spec.Ports[0], nil
+	}
+	for _, p := range spec.Ports {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Port{}, fmt.Errorf("app %q has no port named %q", spec.Name, name)
+}
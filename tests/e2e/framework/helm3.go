@@ -0,0 +1,199 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/strvals"
+
+	"istio.io/istio/tests/util"
+	"istio.io/pkg/log"
+)
+
+// helm3InstallerName selects helm3Installer via the -installer flag.
+const helm3InstallerName = "helm3"
+
+var helm3Timeout = flag.Duration("helm3_timeout", 10*time.Minute,
+	"Timeout passed to the Helm v3 SDK install/upgrade action, including time spent waiting for "+
+		"resources to become ready")
+
+// helm3Installer installs Istio with the Helm v3 Go SDK (action.Install/action.Upgrade) instead
+// of shelling out to the helm2/tiller CLI path used by helmInstaller. There is no tiller
+// deployment step: Helm v3 talks to the cluster directly.
+type helm3Installer struct {
+	k *KubeInfo
+}
+
+func (hi *helm3Installer) Name() string { return helm3InstallerName }
+
+func (hi *helm3Installer) Install(ctx context.Context, k *KubeInfo) error {
+	hi.k = k
+	return hi.installOrUpgrade(false, "")
+}
+
+func (hi *helm3Installer) Uninstall(ctx context.Context) error {
+	k := hi.k
+	cfg, err := hi.actionConfig()
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	uninstall := action.NewUninstall(cfg)
+	if _, err := uninstall.Run(istioHelmChartName); err != nil {
+		// If fail don't return so other cleanup activities can complete
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Could not uninstall %s", istioHelmChartName)))
+	}
+
+	if *useCNI || *ambientMode {
+		if err := util.HelmDelete("istio-cni"); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Helm delete of chart %s failed", "istio-cni")))
+		}
+	}
+
+	if *ambientMode {
+		if err := util.HelmDelete(ztunnelChartName); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Helm delete of chart %s failed", ztunnelChartName)))
+		}
+	}
+
+	if err := util.DeleteNamespace(k.Namespace, k.KubeConfig); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Failed to delete namespace %s", k.Namespace)))
+	}
+
+	return errs
+}
+
+func (hi *helm3Installer) Upgrade(ctx context.Context, newVersion string) error {
+	return hi.installOrUpgrade(true, newVersion)
+}
+
+// actionConfig builds the Helm v3 action.Configuration used by every action below, pointed at
+// hi.k's cluster and namespace.
+func (hi *helm3Installer) actionConfig() (*action.Configuration, error) {
+	cfg := new(action.Configuration)
+	restConfig := kube.GetConfig(hi.k.KubeConfig, "", hi.k.Namespace)
+	if err := cfg.Init(restConfig, hi.k.Namespace, "secrets", log.Debugf); err != nil {
+		return nil, fmt.Errorf("failed to init helm v3 action config: %v", err)
+	}
+	return cfg, nil
+}
+
+// installOrUpgrade loads the istio chart and runs either action.Install or action.Upgrade
+// against it, waiting (per Wait/Timeout) for the resulting resources to become ready. When
+// newVersion is non-empty it overrides the chart's global.tag value, mirroring
+// helmInstaller.Upgrade's "helm upgrade --set-string global.tag=" behavior.
+func (hi *helm3Installer) installOrUpgrade(upgrade bool, newVersion string) error {
+	k := hi.k
+	chrt, err := loader.Load(filepath.Join(k.ReleaseDir, istioHelmInstallDir))
+	if err != nil {
+		return fmt.Errorf("failed to load istio chart from %s: %v", istioHelmInstallDir, err)
+	}
+
+	vals, err := hi.values(newVersion)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := hi.actionConfig()
+	if err != nil {
+		return err
+	}
+
+	if upgrade {
+		up := action.NewUpgrade(cfg)
+		up.Namespace = k.Namespace
+		up.Timeout = *helm3Timeout
+		up.Wait = true
+		_, err = up.Run(istioHelmChartName, chrt, vals)
+		if err != nil {
+			return fmt.Errorf("helm v3 upgrade of %s failed: %v", istioHelmChartName, err)
+		}
+		return nil
+	}
+
+	inst := action.NewInstall(cfg)
+	inst.Namespace = k.Namespace
+	inst.ReleaseName = istioHelmChartName
+	inst.CreateNamespace = true
+	inst.Timeout = *helm3Timeout
+	inst.Wait = true
+	if _, err := inst.Run(chrt, vals); err != nil {
+		return fmt.Errorf("helm v3 install of %s failed: %v", istioHelmChartName, err)
+	}
+	return nil
+}
+
+// values builds the typed value overrides for the istio chart from the e2e flags, then layers
+// -valueFile and any -set values from helmSetValues on top: values from -valueFile take
+// precedence over the flag-derived ones, and -set values take precedence over both.
+func (hi *helm3Installer) values(newVersion string) (map[string]interface{}, error) {
+	k := hi.k
+	vals := map[string]interface{}{
+		"global": map[string]interface{}{
+			"mtls": map[string]interface{}{
+				"enabled": *authEnable,
+			},
+			"oneNamespace": !*clusterWide,
+			"outboundTrafficPolicy": map[string]interface{}{
+				"mode": *outboundTrafficPolicy,
+			},
+			"useMCP": *useMCP,
+		},
+		"galley": map[string]interface{}{
+			"enabled": *useMCP || *useGalleyConfigValidator,
+		},
+		"gateways": map[string]interface{}{
+			"istio-egressgateway": map[string]interface{}{
+				"enabled": *enableEgressGateway,
+			},
+		},
+	}
+	setComponentImage(vals, "pilot", *pilotHub, *pilotTag)
+	setComponentImage(vals, "mixer", *mixerHub, *mixerTag)
+	setComponentImage(vals, "galley", *galleyHub, *galleyTag)
+	setComponentImage(vals, "citadel", *caHub, *caTag)
+	setComponentImage(vals, "sidecarInjectorWebhook", *sidecarInjectorHub, *sidecarInjectorTag)
+
+	if newVersion != "" {
+		global, _ := vals["global"].(map[string]interface{})
+		global["tag"] = newVersion
+	}
+
+	if *valueFile != "" {
+		overrides, err := chartutil.ReadValuesFile(filepath.Join(k.ReleaseDir, istioHelmInstallDir, *valueFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value file %s: %v", *valueFile, err)
+		}
+		vals = chartutil.CoalesceTables(overrides, vals)
+	}
+	for _, v := range helmSetValues {
+		if err := strvals.ParseInto(v, vals); err != nil {
+			return nil, fmt.Errorf("failed to parse --set value %q: %v", v, err)
+		}
+	}
+
+	return vals, nil
+}
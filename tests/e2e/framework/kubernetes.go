@@ -32,6 +32,9 @@ import (
 
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	testKube "istio.io/istio/pkg/test/kube"
 	"istio.io/istio/pkg/test/util/retry"
@@ -39,6 +42,15 @@ import (
 	"istio.io/pkg/log"
 )
 
+// GVRs of the resources KubeInfo's ReadinessWaiter watches in place of the old shell poll loops.
+var (
+	podsGVR              = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+	namespaceGVR         = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+	validatingWebhookGVR = schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"}
+	istioOperatorGVR     = schema.GroupVersionResource{Group: "install.istio.io", Version: "v1alpha1", Resource: "istiooperators"}
+	deploymentsGVR       = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
 const (
 	yamlSuffix                     = ".yaml"
 	istioInstallDir                = "install/kubernetes"
@@ -84,13 +96,16 @@ const (
 	// PrimaryCluster identifies the primary cluster
 	PrimaryCluster = "primary"
 	// RemoteCluster identifies the remote cluster
-	RemoteCluster = "remote"
+	RemoteCluster      = "remote"
+	vclusterChartName  = "vcluster"
+	vclusterKubeConfig = "vcluster.yaml"
+
+	readinessPollInterval = 1 * time.Second
+	readinessPollTimeout  = 180 * time.Second
 
 	kubernetesReadinessTimeout        = time.Second * 180
-	kubernetesReadinessInterval       = 200 * time.Millisecond
 	validationWebhookReadinessTimeout = time.Minute
 	istioOperatorTimeout              = time.Second * 300
-	istioOperatorFreq                 = time.Second * 10
 	validationWebhookReadinessFreq    = 100 * time.Millisecond
 )
 
@@ -125,20 +140,40 @@ var (
 	imagePullPolicy     = flag.String("image_pull_policy", "", "Specifies an override for the Docker image pull policy to be used")
 	multiClusterDir     = flag.String("cluster_registry_dir", "",
 		"Directory name for the cluster registry config. When provided a multicluster test is run across two clusters.")
-	splitHorizon             = flag.Bool("split_horizon", false, "Set up a split horizon EDS multi-cluster test environment")
+	splitHorizon = flag.Bool("split_horizon", false, "Set up a split horizon EDS multi-cluster test environment")
+	multiPrimary = flag.Bool("multi_primary", false,
+		"Set up a multi-primary multicluster test environment: every cluster in -primary_kubeconfigs runs a full "+
+			"control plane and peers with the others via east-west gateways")
+	primaryKubeConfigs = flag.String("primary_kubeconfigs", "",
+		"Comma separated list of kubeconfig files, one per primary cluster, used when -multi_primary is set. "+
+			"The first entry is treated as the local/host cluster.")
 	useGalleyConfigValidator = flag.Bool("use_galley_config_validator", true, "Use galley configuration validation webhook")
-	installer                = flag.String("installer", "kubectl", "Istio installer, default to kubectl, or helm")
+	installer                = flag.String("installer", "kubectl", "Istio installer, default to kubectl, or helm (Helm v2/tiller) or helm3 (Helm v3 SDK)")
 	useMCP                   = flag.Bool("use_mcp", true, "use MCP for configuring Istio components")
 	useOperator              = flag.Bool("use_operator", false, "use Operator to deploy Istio components")
 	outboundTrafficPolicy    = flag.String("outbound_trafficpolicy", "ALLOW_ANY", "Istio outbound traffic policy, default to ALLOW_ANY")
 	enableEgressGateway      = flag.Bool("enable_egressgateway", false, "enable egress gateway, default to false")
 	useCNI                   = flag.Bool("use_cni", false,
 		"Install the Istio CNI which will add the IP table rules for Envoy instead of the init container")
-	cniHelmRepo  = flag.String("cni_helm_repo", "istio.io/istio-cni", "Name of the Istio CNI helm repo")
+	cniHelmRepo = flag.String("cni_helm_repo", "istio.io/istio-cni", "Name of the Istio CNI helm repo")
+	ambientMode = flag.Bool("ambient_mode", false,
+		"Install the CNI plus a per-node ztunnel proxy and skip sidecar injection entirely, for an "+
+			"ambient mesh data plane instead of per-pod sidecars. Implies -use_cni. Only the kubectl and "+
+			"operator installers act on it, and not with -multi_primary: the helm and helm3 installers "+
+			"and the -multi_primary install path deploy neither the CNI nor ztunnel for it.")
 	kubeInjectCM = flag.String("kube_inject_configmap", "",
 		"Configmap to use by the istioctl kube-inject command.")
 	valueFile     = flag.String("valueFile", "", "Istio value yaml file when helm is used")
 	helmSetValues helmSetValueList
+	useVCluster   = flag.Bool("use_vcluster", false,
+		"Provision an ephemeral virtual Kubernetes cluster (vcluster) inside the host cluster for this test run, "+
+			"instead of installing Istio directly into the host cluster. Allows multiple test runs to share a host "+
+			"cluster without interfering with each other.")
+	vclusterHelmRepo = flag.String("vcluster_helm_repo", "https://charts.loft.sh", "Helm repo used to install the vcluster chart")
+	revisionsFlag    = flag.String("revisions", "",
+		"Comma separated list of Istio revisions to install side-by-side in the test namespace, e.g. "+
+			"1-10,1-11, for canary/control-plane upgrade testing. Each revision gets its own istiod "+
+			"Deployment and sidecar injector webhook.")
 )
 
 // Support for multiple values for helm installation
@@ -166,6 +201,25 @@ type appPodsInfo struct {
 	PodsMutex sync.Mutex
 }
 
+// Revision describes one Istio control plane revision installed side-by-side with others in the
+// test namespace, for canary/control-plane upgrade testing.
+type Revision struct {
+	// Name is the revision tag applied to istiod's Deployment and its sidecar injector
+	// MutatingWebhookConfiguration, e.g. "1-10".
+	Name string
+}
+
+// ClusterInfo describes a single Kubernetes cluster participating in the test topology.
+type ClusterInfo struct {
+	// Name identifies the cluster, e.g. PrimaryCluster, RemoteCluster, or a generated
+	// "clusterN" name in multi-primary mode.
+	Name string
+	// KubeConfig is the path to the kubeconfig used to reach this cluster.
+	KubeConfig string
+	// KubeAccessor talks to this cluster's API server.
+	KubeAccessor *testKube.Accessor
+}
+
 // KubeInfo gathers information for kubectl
 type KubeInfo struct {
 	Namespace string
@@ -197,8 +251,31 @@ type KubeInfo struct {
 	// Use baseversion if not empty.
 	BaseVersion string
 
-	appPods  map[string]*appPodsInfo
-	Clusters map[string]string
+	// installer performs the actual install/uninstall/upgrade of Istio, chosen in Setup
+	// based on the -installer and -use_operator flags.
+	installer Installer
+
+	// vcluster provisioned the virtual cluster KubeConfig points at, when -use_vcluster is set.
+	// nil otherwise.
+	vcluster *vclusterProvisioner
+
+	// Revisions lists the Istio control plane revisions installed side-by-side in the test
+	// namespace, populated from -revisions.
+	Revisions []Revision
+	// revisionIstioctls caches the per-revision *Istioctl returned by IstioctlForRevision.
+	revisionIstioctls map[string]*Istioctl
+
+	appPods map[string]*appPodsInfo
+
+	// readinessWaiter watches resources (pods, the galley validating webhook, the IstioOperator
+	// CR) via shared client-go informers instead of shell polling. Lazily created by waiter().
+	readinessWaiter *testKube.ReadinessWaiter
+
+	// Clusters holds every cluster participating in the test topology, in the order
+	// they should be iterated (e.g. for install or cross-cluster secret exchange).
+	// In the common case this is a single PrimaryCluster/RemoteCluster pair; with
+	// -multi_primary it is the ordered list of clusters from -primary_kubeconfigs.
+	Clusters []*ClusterInfo
 
 	KubeConfig         string
 	KubeAccessor       *testKube.Accessor
@@ -208,6 +285,73 @@ type KubeInfo struct {
 	RemoteIstioctl     *Istioctl
 }
 
+// vclusterProvisioner provisions an ephemeral virtual Kubernetes cluster (vcluster) inside a
+// host cluster, used with -use_vcluster so multiple test runs can share a host cluster without
+// interfering with each other.
+type vclusterProvisioner struct {
+	// releaseName is the helm release name for the vcluster instance.
+	releaseName string
+	// namespace hosts the vcluster instance in the host cluster.
+	namespace string
+	// hostKubeConfig is the kubeconfig used to reach the host cluster.
+	hostKubeConfig string
+}
+
+// newVClusterProvisioner creates a provisioner that will host its vcluster in a namespace
+// derived from runID, so concurrent test runs against the same host cluster don't collide.
+func newVClusterProvisioner(runID string) *vclusterProvisioner {
+	return &vclusterProvisioner{
+		releaseName: "vc-" + runID,
+		namespace:   "vcluster-" + runID,
+	}
+}
+
+// provision installs the vcluster chart into the host cluster (reached via hostKubeConfig) and
+// returns the path to a kubeconfig file that reaches the resulting virtual cluster.
+func (v *vclusterProvisioner) provision(tmpDir, hostKubeConfig string) (string, error) {
+	v.hostKubeConfig = hostKubeConfig
+
+	if err := util.CreateNamespace(v.namespace, v.hostKubeConfig); err != nil {
+		return "", fmt.Errorf("unable to create namespace %s for vcluster: %v", v.namespace, err)
+	}
+
+	setValue := fmt.Sprintf(" --set-string vcluster.kubeConfigContextName=%s --kubeconfig=%s", v.releaseName, v.hostKubeConfig)
+	if err := util.HelmInstall(*vclusterHelmRepo, v.releaseName, "", v.namespace, setValue); err != nil {
+		return "", fmt.Errorf("failed to install vcluster release %s: %v", v.releaseName, err)
+	}
+
+	if running := util.CheckPodsRunning(v.namespace, v.hostKubeConfig); !running {
+		return "", fmt.Errorf("timeout waiting for vcluster %s to become ready", v.releaseName)
+	}
+
+	// The vcluster chart publishes a synthesized kubeconfig reaching the virtual control plane
+	// in a secret named "vc-<releaseName>" inside its hosting namespace.
+	kubeConfigPath := filepath.Join(tmpDir, vclusterKubeConfig)
+	out, err := util.Shell("kubectl --kubeconfig=%s -n %s get secret vc-%s -o jsonpath={.data.config} | base64 --decode",
+		v.hostKubeConfig, v.namespace, v.releaseName)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch vcluster kubeconfig for %s: %v", v.releaseName, err)
+	}
+	if err := ioutil.WriteFile(kubeConfigPath, []byte(out), 0600); err != nil {
+		return "", fmt.Errorf("failed to write vcluster kubeconfig %s: %v", kubeConfigPath, err)
+	}
+
+	log.Infof("Provisioned vcluster %s in namespace %s, kubeconfig at %s", v.releaseName, v.namespace, kubeConfigPath)
+	return kubeConfigPath, nil
+}
+
+// teardown uninstalls the vcluster release and deletes its hosting namespace in the host cluster.
+func (v *vclusterProvisioner) teardown() error {
+	var errs error
+	if err := util.HelmDelete(v.releaseName); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("failed to delete vcluster release %s", v.releaseName)))
+	}
+	if err := util.DeleteNamespace(v.namespace, v.hostKubeConfig); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("failed to delete vcluster namespace %s", v.namespace)))
+	}
+	return errs
+}
+
 func getClusterWideInstallFile() string {
 	var istioYaml string
 	if *authEnable {
@@ -311,6 +455,21 @@ func newKubeInfo(tmpDir, runID, baseVersion string) (*KubeInfo, error) {
 		}
 	}
 
+	var vcluster *vclusterProvisioner
+	if *useVCluster {
+		vcluster = newVClusterProvisioner(*namespace)
+		if kubeConfig, err = vcluster.provision(tmpDir, kubeConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	var revisions []Revision
+	if *revisionsFlag != "" {
+		for _, r := range strings.Split(*revisionsFlag, ",") {
+			revisions = append(revisions, Revision{Name: r})
+		}
+	}
+
 	a := NewAppManager(tmpDir, *namespace, i, kubeConfig, true)
 
 	kubeAccessor, err := testKube.NewAccessor(kubeConfig, tmpDir)
@@ -318,13 +477,20 @@ func newKubeInfo(tmpDir, runID, baseVersion string) (*KubeInfo, error) {
 		return nil, err
 	}
 
-	clusters := make(map[string]string)
 	appPods := make(map[string]*appPodsInfo)
-	clusters[PrimaryCluster] = kubeConfig
-	appPods[PrimaryCluster] = &appPodsInfo{}
-	if remoteKubeConfig != "" {
-		clusters[RemoteCluster] = remoteKubeConfig
-		appPods[RemoteCluster] = &appPodsInfo{}
+	var clusters []*ClusterInfo
+	if *multiPrimary {
+		clusters, err = newMultiPrimaryClusters(tmpDir, appPods)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		clusters = append(clusters, &ClusterInfo{Name: PrimaryCluster, KubeConfig: kubeConfig, KubeAccessor: kubeAccessor})
+		appPods[PrimaryCluster] = &appPodsInfo{}
+		if remoteKubeConfig != "" {
+			clusters = append(clusters, &ClusterInfo{Name: RemoteCluster, KubeConfig: remoteKubeConfig, KubeAccessor: remoteKubeAccessor})
+			appPods[RemoteCluster] = &appPodsInfo{}
+		}
 	}
 
 	log.Infof("Using release dir: %s", releaseDir)
@@ -349,9 +515,67 @@ func newKubeInfo(tmpDir, runID, baseVersion string) (*KubeInfo, error) {
 		RemoteKubeAccessor: remoteKubeAccessor,
 		appPods:            appPods,
 		Clusters:           clusters,
+		vcluster:           vcluster,
+		Revisions:          revisions,
+		revisionIstioctls:  make(map[string]*Istioctl),
 	}, nil
 }
 
+// newMultiPrimaryClusters builds the ordered ClusterInfo list for a -multi_primary topology, one
+// entry per kubeconfig in -primary_kubeconfigs. Every cluster in the list is expected to run its
+// own full Istio control plane plus an east-west gateway, peering with the others.
+func newMultiPrimaryClusters(tmpDir string, appPods map[string]*appPodsInfo) ([]*ClusterInfo, error) {
+	if *primaryKubeConfigs == "" {
+		return nil, errors.New("-multi_primary requires -primary_kubeconfigs to list at least two kubeconfig files")
+	}
+	kubeConfigFiles := strings.Split(*primaryKubeConfigs, ",")
+	if len(kubeConfigFiles) < 2 {
+		return nil, errors.New("-multi_primary requires at least two entries in -primary_kubeconfigs")
+	}
+
+	clusters := make([]*ClusterInfo, 0, len(kubeConfigFiles))
+	for idx, kubeConfigFile := range kubeConfigFiles {
+		name := fmt.Sprintf("cluster%d", idx+1)
+		accessor, err := testKube.NewAccessor(kubeConfigFile, tmpDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create accessor for %s (%s): %v", name, kubeConfigFile, err)
+		}
+		clusters = append(clusters, &ClusterInfo{Name: name, KubeConfig: kubeConfigFile, KubeAccessor: accessor})
+		appPods[name] = &appPodsInfo{}
+	}
+	return clusters, nil
+}
+
+// clusterByName returns the ClusterInfo for the given cluster name, or nil if no such cluster
+// is part of the current topology.
+func (k *KubeInfo) clusterByName(name string) *ClusterInfo {
+	for _, c := range k.Clusters {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ClusterKubeconfig returns the kubeconfig path for the named cluster, so tests can target any
+// cluster in the topology by name. It returns an error if name is not part of the current
+// topology, instead of the nil pointer dereference a direct clusterByName lookup would risk.
+func (k *KubeInfo) ClusterKubeconfig(name string) (string, error) {
+	c := k.clusterByName(name)
+	if c == nil {
+		return "", fmt.Errorf("unknown cluster %q", name)
+	}
+	return c.KubeConfig, nil
+}
+
+// waiter returns this KubeInfo's ReadinessWaiter, creating it on first use.
+func (k *KubeInfo) waiter() *testKube.ReadinessWaiter {
+	if k.readinessWaiter == nil {
+		k.readinessWaiter = testKube.NewReadinessWaiter()
+	}
+	return k.readinessWaiter
+}
+
 // IsClusterWide indicates whether or not the environment is configured for a cluster-wide deployment.
 func (k *KubeInfo) IsClusterWide() bool {
 	return *clusterWide
@@ -393,28 +617,9 @@ func (k *KubeInfo) Setup() error {
 	}
 
 	if !*skipSetup {
-		if *installer == helmInstallerName {
-			// install helm tiller first
-			yamlDir := filepath.Join(istioInstallDir+"/"+helmInstallerName, helmServiceAccountFile)
-			baseHelmServiceAccountYaml := filepath.Join(k.ReleaseDir, yamlDir)
-			if err = k.deployTiller(baseHelmServiceAccountYaml); err != nil {
-				log.Error("Failed to deploy helm tiller.")
-				return err
-			}
-
-			// install istio using helm
-			if err = k.deployIstioWithHelm(); err != nil {
-				log.Error("Failed to deploy Istio with helm.")
-				return err
-			}
-
-			// execute helm test for istio
-			if err = k.executeHelmTest(); err != nil {
-				log.Error("Failed to execute Istio helm tests.")
-				return err
-			}
-		} else if err = k.deployIstio(); err != nil {
-			log.Error("Failed to deploy Istio.")
+		k.installer = newInstaller(k)
+		if err = k.installer.Install(context.Background(), k); err != nil {
+			log.Errorf("Failed to deploy Istio with the %s installer.", k.installer.Name())
 			return err
 		}
 		// Create the ingress secret.
@@ -429,6 +634,127 @@ func (k *KubeInfo) Setup() error {
 	return nil
 }
 
+// Installer abstracts the mechanism used to install, tear down, and upgrade Istio for a test
+// run, so that KubeInfo.Setup/Teardown don't need to hardcode the choice between kubectl, helm,
+// and the IstioOperator.
+type Installer interface {
+	// Install deploys Istio into the cluster(s) described by k.
+	Install(ctx context.Context, k *KubeInfo) error
+	// Uninstall removes everything Install deployed.
+	Uninstall(ctx context.Context) error
+	// Upgrade installs newVersion over the existing deployment.
+	Upgrade(ctx context.Context, newVersion string) error
+	// Name identifies the installer, for logging.
+	Name() string
+}
+
+// newInstaller picks the Installer implementation to use based on the -installer and
+// -use_operator flags.
+func newInstaller(k *KubeInfo) Installer {
+	switch {
+	case *useOperator:
+		return &operatorInstaller{k: k}
+	case *installer == helm3InstallerName:
+		return &helm3Installer{k: k}
+	case *installer == helmInstallerName:
+		return &helmInstaller{k: k}
+	default:
+		return &kubectlInstaller{k: k}
+	}
+}
+
+// kubectlInstaller installs Istio by applying the generated installation manifest with kubectl.
+type kubectlInstaller struct {
+	k *KubeInfo
+}
+
+func (ki *kubectlInstaller) Name() string { return "kubectl" }
+
+func (ki *kubectlInstaller) Install(ctx context.Context, k *KubeInfo) error {
+	ki.k = k
+	return k.deployIstio()
+}
+
+func (ki *kubectlInstaller) Uninstall(ctx context.Context) error {
+	return ki.k.teardownKubectl()
+}
+
+func (ki *kubectlInstaller) Upgrade(ctx context.Context, newVersion string) error {
+	ki.k.BaseVersion = newVersion
+	return ki.k.deployIstio()
+}
+
+// helmInstaller installs Istio by shelling out to the Helm 2 CLI.
+type helmInstaller struct {
+	k *KubeInfo
+}
+
+func (hi *helmInstaller) Name() string { return helmInstallerName }
+
+func (hi *helmInstaller) Install(ctx context.Context, k *KubeInfo) error {
+	hi.k = k
+	yamlDir := filepath.Join(istioInstallDir+"/"+helmInstallerName, helmServiceAccountFile)
+	baseHelmServiceAccountYaml := filepath.Join(k.ReleaseDir, yamlDir)
+	if err := k.deployTiller(baseHelmServiceAccountYaml); err != nil {
+		return err
+	}
+	if err := k.deployIstioWithHelm(); err != nil {
+		return err
+	}
+	return k.executeHelmTest()
+}
+
+func (hi *helmInstaller) Uninstall(ctx context.Context) error {
+	return hi.k.teardownHelm()
+}
+
+func (hi *helmInstaller) Upgrade(ctx context.Context, newVersion string) error {
+	_, err := util.Shell("helm upgrade %s %s --kubeconfig=%s --set-string global.tag=%s",
+		istioHelmChartName, filepath.Join(hi.k.ReleaseDir, istioHelmInstallDir), hi.k.KubeConfig, newVersion)
+	return err
+}
+
+// operatorInstaller installs Istio by applying an IstioOperator custom resource and waiting for
+// the istio-operator controller to converge it to HEALTHY.
+type operatorInstaller struct {
+	k *KubeInfo
+}
+
+func (oi *operatorInstaller) Name() string { return "operator" }
+
+func (oi *operatorInstaller) Install(ctx context.Context, k *KubeInfo) error {
+	oi.k = k
+	return k.deployIstio()
+}
+
+func (oi *operatorInstaller) Uninstall(ctx context.Context) error {
+	return oi.k.teardownOperator()
+}
+
+func (oi *operatorInstaller) Upgrade(ctx context.Context, newVersion string) error {
+	if *legacyOperatorManifest {
+		yamlDir := filepath.Join(istioInstallDir, authOperatorInstallFile)
+		baseIstioYaml := filepath.Join(oi.k.ReleaseDir, yamlDir)
+		testIstioYaml := filepath.Join(oi.k.TmpDir, "yaml", authOperatorInstallFile)
+		content, err := ioutil.ReadFile(baseIstioYaml)
+		if err != nil {
+			return err
+		}
+		content = updateInjectVersion(newVersion, content)
+		if err := ioutil.WriteFile(testIstioYaml, content, 0600); err != nil {
+			return err
+		}
+		if err := util.KubeApply(operatorCRNamespace, testIstioYaml, oi.k.KubeConfig); err != nil {
+			return err
+		}
+		return oi.k.waitForIstioOperator()
+	}
+
+	cr := oi.k.buildIstioOperatorCR()
+	cr.Spec.Tag = newVersion
+	return oi.k.applyIstioOperatorCR(cr)
+}
+
 // PilotHub exposes the Docker hub used for the pilot image.
 func (k *KubeInfo) PilotHub() string {
 	return *pilotHub
@@ -526,141 +852,141 @@ func (k *KubeInfo) Teardown() error {
 		return nil
 	}
 	var errs error
-	if *installer == helmInstallerName {
-		// clean up using helm
-		err := util.HelmDelete(istioHelmChartName)
-		if err != nil {
-			// If fail don't return so other cleanup activities can complete
-			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Could not delete %s", istioHelmChartName)))
-		}
-
-		if *useCNI {
-			err := util.HelmDelete("istio-cni")
-			if err != nil {
-				// If fail don't return so other cleanup activities can complete
-				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Helm delete of chart %s failed", "istio-cni")))
-			}
+	if k.installer == nil {
+		k.installer = newInstaller(k)
+	}
+	if err := k.installer.Uninstall(context.Background()); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("%s installer failed to uninstall Istio", k.installer.Name())))
+	}
+	if *multiClusterDir != "" {
+		if err := util.DeleteNamespace(k.Namespace, k.RemoteKubeConfig); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Failed to delete namespace %s on remote cluster", k.Namespace)))
 		}
+	}
 
-		if err := util.DeleteNamespace(k.Namespace, k.KubeConfig); err != nil {
-			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Failed to delete namespace %s", k.Namespace)))
+	if k.vcluster != nil {
+		if err := k.vcluster.teardown(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "failed to tear down vcluster"))
 		}
-	} else {
-		if *useAutomaticInjection {
-			testSidecarInjectorYAML := filepath.Join(k.TmpDir, "yaml", *sidecarInjectorFile)
+	}
 
-			if err := util.KubeDelete(k.Namespace, testSidecarInjectorYAML, k.KubeConfig); err != nil {
-				log.Errorf("Istio sidecar injector %s deletion failed", testSidecarInjectorYAML)
-				return err
-			}
-		}
+	log.Infof("Waiting for namespace %v to be cleaned up", k.Namespace)
+	if err := k.WaitForClean(context.Background()); err != nil {
+		// NB: waiting much past 230 seconds causes the CI infrastructure to terminate the
+		// test run without reporting what actually failed in the deletion.
+		log.Errorf("Failed to clean up namespace %s: %v", k.Namespace, err)
+		return errs
+	}
 
-		var istioYaml string
-		if *clusterWide {
-			if *multiClusterDir != "" {
-				if *authEnable {
-					istioYaml = mcAuthInstallFileNamespace
-				} else {
-					istioYaml = mcNonAuthInstallFileNamespace
-				}
-			} else {
-				istioYaml = getClusterWideInstallFile()
-			}
-		}
-		if *useOperator {
-			//save operator logs
-			log.Info("Saving istio-operator logs")
-			if err := util.FetchAndSaveClusterLogs("istio-operator", k.TmpDir, k.KubeConfig); err != nil {
-				log.Errorf("Failed to save operator logs: %v", err)
-			}
-			// Need an operator unique delete procedure
-			if _, err := util.Shell("kubectl -n istio-operator delete IstioOperator example-istiocontrolplane"); err != nil {
-				log.Errorf("Failed to delete the Istio CR.")
-				return err
-			}
-			if _, err := util.Shell("kubectl delete ns istio-operator --kubeconfig=%s",
-				k.KubeConfig); err != nil {
-				log.Errorf("Failed to delete istio-operator namespace.")
-				return err
-			}
-			if _, err := util.Shell("kubectl delete ns %s --kubeconfig=%s",
-				k.Namespace, k.KubeConfig); err != nil {
-				log.Errorf("Failed to delete %s namespace.", k.Namespace)
-				return err
-			}
-		} else {
-			testIstioYaml := filepath.Join(k.TmpDir, "yaml", istioYaml)
-			if err := util.KubeDelete(k.Namespace, testIstioYaml, k.KubeConfig); err != nil {
-				log.Infof("Safe to ignore resource not found errors in kubectl delete -f %s", testIstioYaml)
-			}
+	log.Infof("Namespace %s deletion complete", k.Namespace)
 
-			if err := util.DeleteNamespace(k.Namespace, k.KubeConfig); err != nil {
-				log.Errorf("Failed to delete namespace %s", k.Namespace)
-				return err
-			}
+	return errs
+}
 
-			// ClusterRoleBindings are not namespaced and need to be deleted separately
-			if _, err := util.Shell("kubectl get --kubeconfig=%s clusterrolebinding -o jsonpath={.items[*].metadata.name}"+
-				"|xargs -n 1|fgrep %s|xargs kubectl delete --kubeconfig=%s clusterrolebinding", k.KubeConfig,
-				k.Namespace, k.KubeConfig); err != nil {
-				log.Errorf("Failed to delete clusterrolebindings associated with namespace %s", k.Namespace)
-				return err
-			}
+// teardownHelm cleans up an Istio deployment installed by helmInstaller.
+func (k *KubeInfo) teardownHelm() error {
+	var errs error
+	if err := util.HelmDelete(istioHelmChartName); err != nil {
+		// If fail don't return so other cleanup activities can complete
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Could not delete %s", istioHelmChartName)))
+	}
 
-			// ClusterRoles are not namespaced and need to be deleted separately
-			if _, err := util.Shell("kubectl get --kubeconfig=%s clusterrole -o jsonpath={.items[*].metadata.name}"+
-				"|xargs -n 1|fgrep %s|xargs kubectl delete --kubeconfig=%s clusterrole", k.KubeConfig,
-				k.Namespace, k.KubeConfig); err != nil {
-				log.Errorf("Failed to delete clusterroles associated with namespace %s", k.Namespace)
-				return err
-			}
+	if *useCNI || *ambientMode {
+		if err := util.HelmDelete("istio-cni"); err != nil {
+			// If fail don't return so other cleanup activities can complete
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Helm delete of chart %s failed", "istio-cni")))
 		}
 	}
-	if *multiClusterDir != "" {
-		if err := util.DeleteNamespace(k.Namespace, k.RemoteKubeConfig); err != nil {
-			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Failed to delete namespace %s on remote cluster", k.Namespace)))
+
+	if *ambientMode {
+		if err := util.HelmDelete(ztunnelChartName); err != nil {
+			// If fail don't return so other cleanup activities can complete
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Helm delete of chart %s failed", ztunnelChartName)))
 		}
 	}
 
-	// NB: Increasing maxAttempts much past 230 seconds causes the CI infrastructure
-	// to terminate the test run not reporting what actually failed in the deletion.
-	maxAttempts := 180
+	if err := util.DeleteNamespace(k.Namespace, k.KubeConfig); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("Failed to delete namespace %s", k.Namespace)))
+	}
+	return errs
+}
 
-	namespaceDeleted := false
-	validatingWebhookConfigurationExists := false
-	log.Infof("Deleting namespace %v", k.Namespace)
-	for attempts := 1; attempts <= maxAttempts; attempts++ {
-		if *useOperator {
-			namespaceDeleted, _ = util.NamespaceDeleted("istio-operator", k.KubeConfig)
-		} else {
-			namespaceDeleted, _ = util.NamespaceDeleted(k.Namespace, k.KubeConfig)
-		}
-		// As validatingWebhookConfiguration "istio-galley" will
-		// be delete by kubernetes GC controller asynchronously,
-		// we need to ensure it's deleted before return.
-		// TODO: find a more general way as long term solution.
-		validatingWebhookConfigurationExists = util.ValidatingWebhookConfigurationExists("istio-galley", k.KubeConfig)
+// teardownOperator cleans up an Istio deployment installed by operatorInstaller.
+func (k *KubeInfo) teardownOperator() error {
+	//save operator logs
+	log.Info("Saving istio-operator logs")
+	if err := util.FetchAndSaveClusterLogs("istio-operator", k.TmpDir, k.KubeConfig); err != nil {
+		log.Errorf("Failed to save operator logs: %v", err)
+	}
+	// Need an operator unique delete procedure
+	if _, err := util.Shell("kubectl -n %s delete IstioOperator %s --kubeconfig=%s",
+		operatorCRNamespace, operatorCRName, k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete the Istio CR.")
+		return err
+	}
+	if _, err := util.Shell("kubectl delete ns istio-operator --kubeconfig=%s",
+		k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete istio-operator namespace.")
+		return err
+	}
+	if _, err := util.Shell("kubectl delete ns %s --kubeconfig=%s",
+		k.Namespace, k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete %s namespace.", k.Namespace)
+		return err
+	}
+	return nil
+}
+
+// teardownKubectl cleans up an Istio deployment installed by kubectlInstaller.
+func (k *KubeInfo) teardownKubectl() error {
+	if *useAutomaticInjection {
+		testSidecarInjectorYAML := filepath.Join(k.TmpDir, "yaml", *sidecarInjectorFile)
 
-		if namespaceDeleted && !validatingWebhookConfigurationExists {
-			break
+		if err := util.KubeDelete(k.Namespace, testSidecarInjectorYAML, k.KubeConfig); err != nil {
+			log.Errorf("Istio sidecar injector %s deletion failed", testSidecarInjectorYAML)
+			return err
 		}
+	}
 
-		time.Sleep(1 * time.Second)
+	var istioYaml string
+	if *clusterWide {
+		if *multiClusterDir != "" {
+			if *authEnable {
+				istioYaml = mcAuthInstallFileNamespace
+			} else {
+				istioYaml = mcNonAuthInstallFileNamespace
+			}
+		} else {
+			istioYaml = getClusterWideInstallFile()
+		}
 	}
 
-	if !namespaceDeleted {
-		log.Errorf("Failed to delete namespace %s after %v seconds", k.Namespace, maxAttempts)
-		return nil
+	testIstioYaml := filepath.Join(k.TmpDir, "yaml", istioYaml)
+	if err := util.KubeDelete(k.Namespace, testIstioYaml, k.KubeConfig); err != nil {
+		log.Infof("Safe to ignore resource not found errors in kubectl delete -f %s", testIstioYaml)
 	}
 
-	if validatingWebhookConfigurationExists {
-		log.Errorf("Failed to delete validatingwebhookconfiguration istio-galley after %d seconds", maxAttempts)
-		return nil
+	if err := util.DeleteNamespace(k.Namespace, k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete namespace %s", k.Namespace)
+		return err
 	}
 
-	log.Infof("Namespace %s deletion status: %v", k.Namespace, namespaceDeleted)
+	// ClusterRoleBindings are not namespaced and need to be deleted separately
+	if _, err := util.Shell("kubectl get --kubeconfig=%s clusterrolebinding -o jsonpath={.items[*].metadata.name}"+
+		"|xargs -n 1|fgrep %s|xargs kubectl delete --kubeconfig=%s clusterrolebinding", k.KubeConfig,
+		k.Namespace, k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete clusterrolebindings associated with namespace %s", k.Namespace)
+		return err
+	}
 
-	return errs
+	// ClusterRoles are not namespaced and need to be deleted separately
+	if _, err := util.Shell("kubectl get --kubeconfig=%s clusterrole -o jsonpath={.items[*].metadata.name}"+
+		"|xargs -n 1|fgrep %s|xargs kubectl delete --kubeconfig=%s clusterrole", k.KubeConfig,
+		k.Namespace, k.KubeConfig); err != nil {
+		log.Errorf("Failed to delete clusterroles associated with namespace %s", k.Namespace)
+		return err
+	}
+	return nil
 }
 
 // GetAppPods gets a map of app name to pods for that app. If pods are found, the results are cached.
@@ -669,8 +995,12 @@ func (k *KubeInfo) GetAppPods(cluster string) map[string][]string {
 	newMap := k.getAppPods(cluster)
 
 	if len(newMap) == 0 {
-		var err error
-		if newMap, err = util.GetAppPods(k.Namespace, k.Clusters[cluster]); err != nil {
+		kubeConfig, err := k.ClusterKubeconfig(cluster)
+		if err != nil {
+			log.Errorf("Failed to get app pods for namespace %s: %v", k.Namespace, err)
+			return newMap
+		}
+		if newMap, err = util.GetAppPods(k.Namespace, kubeConfig); err != nil {
 			log.Errorf("Failed to get retrieve the app pods for namespace %s", k.Namespace)
 		} else {
 			// Copy the new results to the internal map.
@@ -690,7 +1020,11 @@ func (k *KubeInfo) CheckJobSucceeded(cluster, jobName string) error {
 	}
 
 	retryFn := func(_ context.Context, i int) error {
-		ret, err := util.IsJobSucceeded(k.Namespace, jobName, k.Clusters[cluster])
+		kubeConfig, err := k.ClusterKubeconfig(cluster)
+		if err != nil {
+			return err
+		}
+		ret, err := util.IsJobSucceeded(k.Namespace, jobName, kubeConfig)
 		if err != nil {
 			log.Errorf("Failed to get retrieve the app pods for namespace %s", k.Namespace)
 			return err
@@ -711,15 +1045,25 @@ func (k *KubeInfo) CheckJobSucceeded(cluster, jobName string) error {
 // GetRoutes gets routes from the pod or returns error
 func (k *KubeInfo) GetRoutes(app string) (routes string, err error) {
 	routesURL := "http://localhost:15000/config_dump"
-	for cluster := range k.Clusters {
+	for _, c := range k.Clusters {
+		cluster := c.Name
 		appPods := k.GetAppPods(cluster)
 		if len(appPods[app]) == 0 {
 			return "", errors.Errorf("missing pod names for app %q", app)
 		}
 
 		pod := appPods[app][0]
+		execPod, container := pod, "app"
+		if *ambientMode {
+			// There is no sidecar to ask for its config dump: exec into the ztunnel proxy
+			// running on the same node as the app pod instead.
+			if execPod, err = k.ztunnelPodForAppPod(cluster, pod); err != nil {
+				return "", errors.WithMessage(err, "failed to locate ztunnel proxy")
+			}
+			container = ztunnelContainerName
+		}
 
-		r, e := util.PodExec(k.Namespace, pod, "app", fmt.Sprintf("client --url %s", routesURL), true, k.Clusters[cluster])
+		r, e := util.PodExec(k.Namespace, execPod, container, fmt.Sprintf("client --url %s", routesURL), true, c.KubeConfig)
 		if e != nil {
 			return "", errors.WithMessage(err, "failed to get routes")
 		}
@@ -730,20 +1074,30 @@ func (k *KubeInfo) GetRoutes(app string) (routes string, err error) {
 	return routes, nil
 }
 
-// getAppPods returns a copy of the appPods map. Should only be called by GetAppPods.
+// getAppPods returns a copy of the appPods map, or nil if cluster isn't part of the current
+// topology. Should only be called by GetAppPods.
 func (k *KubeInfo) getAppPods(cluster string) map[string][]string {
-	k.appPods[cluster].PodsMutex.Lock()
-	defer k.appPods[cluster].PodsMutex.Unlock()
+	info, ok := k.appPods[cluster]
+	if !ok {
+		return nil
+	}
+	info.PodsMutex.Lock()
+	defer info.PodsMutex.Unlock()
 
-	return k.deepCopy(k.appPods[cluster].Pods)
+	return k.deepCopy(info.Pods)
 }
 
-// setAppPods sets the app pods with a copy of the given map. Should only be called by GetAppPods.
+// setAppPods sets the app pods with a copy of the given map, a no-op if cluster isn't part of the
+// current topology. Should only be called by GetAppPods.
 func (k *KubeInfo) setAppPods(cluster string, newMap map[string][]string) {
-	k.appPods[cluster].PodsMutex.Lock()
-	defer k.appPods[cluster].PodsMutex.Unlock()
+	info, ok := k.appPods[cluster]
+	if !ok {
+		return
+	}
+	info.PodsMutex.Lock()
+	defer info.PodsMutex.Unlock()
 
-	k.appPods[cluster].Pods = k.deepCopy(newMap)
+	info.Pods = k.deepCopy(newMap)
 }
 
 func (k *KubeInfo) deepCopy(src map[string][]string) map[string][]string {
@@ -755,6 +1109,10 @@ func (k *KubeInfo) deepCopy(src map[string][]string) map[string][]string {
 }
 
 func (k *KubeInfo) deployIstio() error {
+	if *multiPrimary {
+		return k.deployMultiPrimary()
+	}
+
 	istioYaml := nonAuthInstallFileNamespace
 	if *multiClusterDir != "" {
 		if *splitHorizon {
@@ -783,50 +1141,66 @@ func (k *KubeInfo) deployIstio() error {
 		}
 	}
 
-	// Deploy the CNI if enabled
-	if *useCNI {
+	// Deploy the CNI if enabled, or unconditionally in ambient mode, which redirects traffic to
+	// the per-node ztunnel proxy the same way CNI redirects it to the sidecar.
+	if *useCNI || *ambientMode {
 		err := k.deployCNI()
 		if err != nil {
 			log.Errorf("Unable to deply Istio CNI")
 			return err
 		}
 
-		timeout := time.Now().Add(maxCNIDeployTime)
-		var CNIPodName string
-		for time.Now().Before(timeout) {
-			// Check if the CNI pod deployed
-			if CNIPodName, err = util.GetPodName(k.Namespace, "k8s-app=istio-cni-node", k.KubeConfig); err == nil {
-				break
-			}
-
+		if err := k.waiter().WaitForAny(context.Background(), k.KubeConfig, podsGVR, k.Namespace, maxCNIDeployTime,
+			func(pods []*unstructured.Unstructured) (bool, error) {
+				for _, pod := range pods {
+					if pod.GetLabels()["k8s-app"] != "istio-cni-node" {
+						continue
+					}
+					phase, _, err := unstructured.NestedString(pod.Object, "status", "phase")
+					if err != nil {
+						return false, err
+					}
+					if phase == "Running" {
+						return true, nil
+					}
+				}
+				return false, nil
+			}); err != nil {
+			return fmt.Errorf("timeout waiting for CNI to become ready: %v", err)
 		}
+	}
 
-		if CNIPodName == "" {
-			return errors.New("timeout waiting for CNI to deploy")
+	// Ambient mode replaces the sidecar data plane with a DaemonSet of per-node ztunnel proxies,
+	// deployed once the CNI (its prerequisite) is up.
+	if *ambientMode {
+		if err := k.deployZtunnel(); err != nil {
+			log.Errorf("Unable to deploy ztunnel node proxy")
+			return err
 		}
-
-		// Check if the CNI Pod is running.  Note at this point only the CNI is deployed
-		// and it will be the only pod in the namespace
-		if CNIRunning := util.CheckPodsRunning(k.Namespace, k.KubeConfig); !CNIRunning {
-			return errors.New("timeout waiting for CNI to become ready")
+		if err := k.waitForZtunnelReady(k.KubeConfig); err != nil {
+			return fmt.Errorf("timeout waiting for ztunnel to deploy: %v", err)
 		}
-
 	}
 
 	var testIstioYaml string
 	// Use the operator manifest when operator mode enabled
 	if *useOperator {
-		istioYaml = authOperatorInstallFile
-		yamlDir := filepath.Join(istioInstallDir, istioYaml)
-		baseIstioYaml := filepath.Join(k.ReleaseDir, yamlDir)
-		testIstioYaml = filepath.Join(k.TmpDir, "yaml", istioYaml)
-		util.CopyFile(baseIstioYaml, testIstioYaml)
-		if err := util.KubeApply("istio-operator", testIstioYaml, k.KubeConfig); err != nil {
-			log.Errorf("Istio operator %s deployment failed", testIstioYaml)
-			return err
-		}
-		if err := k.waitForIstioOperator(); err != nil {
-			log.Errorf("istio operator fails to deploy Istio: %v", err)
+		if *legacyOperatorManifest {
+			istioYaml = authOperatorInstallFile
+			yamlDir := filepath.Join(istioInstallDir, istioYaml)
+			baseIstioYaml := filepath.Join(k.ReleaseDir, yamlDir)
+			testIstioYaml = filepath.Join(k.TmpDir, "yaml", istioYaml)
+			util.CopyFile(baseIstioYaml, testIstioYaml)
+			if err := util.KubeApply(operatorCRNamespace, testIstioYaml, k.KubeConfig); err != nil {
+				log.Errorf("Istio operator %s deployment failed", testIstioYaml)
+				return err
+			}
+			if err := k.waitForIstioOperator(); err != nil {
+				log.Errorf("istio operator fails to deploy Istio: %v", err)
+				return err
+			}
+		} else if err := k.deployIstioOperatorCR(); err != nil {
+			log.Errorf("istio operator CR failed to deploy Istio: %v", err)
 			return err
 		}
 	} else {
@@ -895,7 +1269,7 @@ func (k *KubeInfo) deployIstio() error {
 
 			err = k.generateRemoteIstioForSplitHorizon(testIstioYaml, remoteNetworkName, *proxyHub, *proxyTag)
 		} else {
-			err = k.generateRemoteIstio(testIstioYaml, *useAutomaticInjection, *proxyHub, *proxyTag)
+			err = k.generateRemoteIstio(testIstioYaml, *useAutomaticInjection && !*ambientMode, *proxyHub, *proxyTag)
 		}
 		if err != nil {
 			log.Errorf("Generating Remote yaml %s failed", testIstioYaml)
@@ -912,7 +1286,9 @@ func (k *KubeInfo) deployIstio() error {
 		time.Sleep(10 * time.Second)
 	}
 
-	if *useAutomaticInjection {
+	// Ambient mode has no sidecar to inject: traffic is redirected to ztunnel instead, so the
+	// injector webhook is skipped entirely rather than installed and left unused.
+	if *useAutomaticInjection && !*ambientMode {
 		baseSidecarInjectorYAML := util.GetResourcePath(filepath.Join(istioInstallDir, *sidecarInjectorFile))
 		testSidecarInjectorYAML := filepath.Join(k.TmpDir, "yaml", *sidecarInjectorFile)
 		if err := k.generateSidecarInjector(baseSidecarInjectorYAML, testSidecarInjectorYAML); err != nil {
@@ -925,30 +1301,268 @@ func (k *KubeInfo) deployIstio() error {
 		}
 	}
 
-	if err := util.CheckDeployments(k.Namespace, maxDeploymentRolloutTime, k.KubeConfig); err != nil {
+	for _, rev := range k.Revisions {
+		if err := k.deployIstioForRevision(rev); err != nil {
+			log.Errorf("Deploying revision %s failed", rev.Name)
+			return err
+		}
+	}
+
+	return k.WaitForIstioReady(context.Background())
+}
+
+// WaitForIstioReady waits, via KubeInfo.waiter's informer-backed Deployments watch, for the Istio
+// control plane Deployments in the test namespace to become available, and, when galley config
+// validation is enabled, for its validating webhook to start accepting requests.
+func (k *KubeInfo) WaitForIstioReady(ctx context.Context) error {
+	if err := k.checkDeployments(k.KubeConfig); err != nil {
 		return err
 	}
 
-	if *useGalleyConfigValidator {
-		timeout := time.Now().Add(maxValidationReadyCheckTime)
-		var validationReady bool
-		for time.Now().Before(timeout) {
-			if _, err := util.ShellSilent("kubectl get validatingwebhookconfiguration istio-galley --kubeconfig=%s", k.KubeConfig); err == nil {
-				validationReady = true
-				break
+	if !*useGalleyConfigValidator {
+		return nil
+	}
+
+	// waitForValdiationWebhook already waits, via KubeInfo.waiter(), for the istio-galley
+	// validatingwebhookconfiguration to exist before probing it live; no separate wait is needed
+	// here.
+	return k.waitForValdiationWebhook()
+}
+
+// deployMultiPrimary installs a full Istio control plane plus an east-west gateway into every
+// cluster in k.Clusters, then exchanges remote-cluster secrets pairwise so each cluster's istiod
+// can discover and route to endpoints in every other cluster.
+func (k *KubeInfo) deployMultiPrimary() error {
+	if len(k.Clusters) < 2 {
+		return errors.New("-multi_primary requires at least two clusters")
+	}
+
+	// Every cluster's install is independent of the others until the secret-exchange step below,
+	// so run them concurrently instead of paying for len(k.Clusters) sequential control plane
+	// rollouts.
+	if err := k.forEachCluster(func(c *ClusterInfo) error {
+		if err := util.CreateNamespace(k.Namespace, c.KubeConfig); err != nil {
+			return fmt.Errorf("unable to create namespace %s on cluster %s: %v", k.Namespace, c.Name, err)
+		}
+		if err := k.deployIstiod(c); err != nil {
+			return fmt.Errorf("failed to deploy istiod on cluster %s: %v", c.Name, err)
+		}
+		if err := k.deployEastWestGateway(c); err != nil {
+			return fmt.Errorf("failed to deploy east-west gateway on cluster %s: %v", c.Name, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Exchange remote-cluster secrets pairwise so every cluster's istiod can discover
+	// service endpoints in every other cluster.
+	for _, local := range k.Clusters {
+		for _, remote := range k.Clusters {
+			if local.Name == remote.Name {
+				continue
 			}
+			if err := util.CreateMultiClusterSecret(k.Namespace, remote.KubeConfig, local.KubeConfig); err != nil {
+				return fmt.Errorf("unable to create remote cluster secret for %s on %s: %v", remote.Name, local.Name, err)
+			}
+		}
+	}
+
+	return k.forEachCluster(func(c *ClusterInfo) error {
+		return k.checkDeployments(c.KubeConfig)
+	})
+}
+
+// checkDeployments waits for every Deployment in k.Namespace to report an "Available" status
+// condition on the cluster reached via kubeConfig, and, in ambient mode, for the ztunnel DaemonSet
+// to additionally report Ready on every node of that cluster. On timeout the returned error
+// includes which Deployments were still unavailable, rather than the old shell-based rollout
+// status check's opaque failure.
+func (k *KubeInfo) checkDeployments(kubeConfig string) error {
+	var unavailable []string
+	if err := k.waiter().WaitForAny(context.Background(), kubeConfig, deploymentsGVR, k.Namespace, maxDeploymentRolloutTime,
+		func(deployments []*unstructured.Unstructured) (bool, error) {
+			unavailable = nil
+			if len(deployments) == 0 {
+				return false, nil
+			}
+			for _, d := range deployments {
+				if !deploymentAvailable(d) {
+					unavailable = append(unavailable, d.GetName())
+				}
+			}
+			return len(unavailable) == 0, nil
+		}); err != nil {
+		return fmt.Errorf("istio control plane deployments in namespace %s never became available (still unavailable: %v): %v",
+			k.Namespace, unavailable, err)
+	}
+	if *ambientMode {
+		return k.waitForZtunnelReady(kubeConfig)
+	}
+	return nil
+}
+
+// deploymentAvailable reports whether d's status.conditions includes an "Available" condition
+// with status "True".
+func deploymentAvailable(d *unstructured.Unstructured) bool {
+	conditions, found, err := unstructured.NestedSlice(d.Object, "status", "conditions")
+	if err != nil || !found {
+		return false
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
 		}
-		if !validationReady {
-			return errors.New("timeout waiting for validatingwebhookconfiguration istio-galley to be created")
+		if condition["type"] == "Available" && condition["status"] == "True" {
+			return true
 		}
+	}
+	return false
+}
 
-		if err := k.waitForValdiationWebhook(); err != nil {
+// CheckCrossClusterEcho calls to's echo service from from, the same way AppManager.Call does, but
+// fails if from and to both resolve to the same cluster: the point of this check is to exercise
+// the east-west gateway and the per-pair secret exchange deployMultiPrimary sets up, which a
+// same-cluster call wouldn't touch at all.
+func (a *AppManager) CheckCrossClusterEcho(ctx context.Context, k *KubeInfo, from, to AppSpec, req CallRequest) (CallResponse, error) {
+	fromCluster := from.Cluster
+	if fromCluster == "" || fromCluster == anyCluster {
+		fromCluster = PrimaryCluster
+	}
+	toCluster := to.Cluster
+	if toCluster == "" || toCluster == anyCluster {
+		toCluster = PrimaryCluster
+	}
+	if fromCluster == toCluster {
+		return CallResponse{}, fmt.Errorf("from %q and to %q both resolve to cluster %s: not a cross-cluster check",
+			from.Name, to.Name, fromCluster)
+	}
+
+	resp, err := a.Call(ctx, k, from, to, req)
+	if err != nil {
+		return CallResponse{}, err
+	}
+	if resp.Count == 0 {
+		return CallResponse{}, fmt.Errorf("cross-cluster call from %q (%s) to %q (%s) got no successful responses",
+			from.Name, fromCluster, to.Name, toCluster)
+	}
+	return resp, nil
+}
+
+// forEachCluster runs fn against every cluster in k.Clusters concurrently, waiting for all of
+// them to finish and returning the first error encountered (if any).
+func (k *KubeInfo) forEachCluster(fn func(c *ClusterInfo) error) error {
+	errs := make(chan error, len(k.Clusters))
+	var wg sync.WaitGroup
+	for _, c := range k.Clusters {
+		wg.Add(1)
+		go func(c *ClusterInfo) {
+			defer wg.Done()
+			errs <- fn(c)
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// deployIstiod installs a full Istio control plane into the given cluster.
+func (k *KubeInfo) deployIstiod(c *ClusterInfo) error {
+	yamlDir := filepath.Join(istioInstallDir, mcNonAuthInstallFileNamespace)
+	baseIstioYaml := filepath.Join(k.ReleaseDir, yamlDir)
+	testIstioYaml := filepath.Join(k.TmpDir, "yaml", c.Name+"-"+mcNonAuthInstallFileNamespace)
+	if err := k.generateIstio(baseIstioYaml, testIstioYaml); err != nil {
+		return err
+	}
+	return util.KubeApply(k.Namespace, testIstioYaml, c.KubeConfig)
+}
+
+// deployEastWestGateway installs the east-west gateway used for cross-cluster traffic into the
+// given cluster. Every cluster in a multi-primary topology gets one so peers can reach its
+// endpoints even when they aren't directly routable.
+func (k *KubeInfo) deployEastWestGateway(c *ClusterInfo) error {
+	yamlDir := filepath.Join(istioInstallDir, mcRemoteInstallFile)
+	baseIstioYaml := filepath.Join(k.ReleaseDir, yamlDir)
+	testIstioYaml := filepath.Join(k.TmpDir, "yaml", c.Name+"-eastwestgateway.yaml")
+	if err := k.generateIstio(baseIstioYaml, testIstioYaml); err != nil {
+		return err
+	}
+	return util.KubeApply(k.Namespace, testIstioYaml, c.KubeConfig)
+}
+
+// deployIstioForRevision installs an additional istiod Deployment and sidecar injector
+// MutatingWebhookConfiguration for rev, side-by-side with any other revisions already running in
+// the test namespace.
+func (k *KubeInfo) deployIstioForRevision(rev Revision) error {
+	yamlDir := filepath.Join(istioInstallDir, nonAuthInstallFileNamespace)
+	baseIstioYaml := filepath.Join(k.ReleaseDir, yamlDir)
+	testIstioYaml := filepath.Join(k.TmpDir, "yaml", fmt.Sprintf("istio-%s.yaml", rev.Name))
+	if err := k.generateIstio(baseIstioYaml, testIstioYaml); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(testIstioYaml)
+	if err != nil {
+		return err
+	}
+	content, err = updateRevision(rev.Name, content)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(testIstioYaml, content, 0600); err != nil {
+		return err
+	}
+	if err := util.KubeApply(k.Namespace, testIstioYaml, k.KubeConfig); err != nil {
+		return err
+	}
+
+	baseSidecarInjectorYAML := util.GetResourcePath(filepath.Join(istioInstallDir, defaultSidecarInjectorFile))
+	testSidecarInjectorYAML := filepath.Join(k.TmpDir, "yaml", fmt.Sprintf("istio-sidecar-injector-%s.yaml", rev.Name))
+	if err := k.generateSidecarInjector(baseSidecarInjectorYAML, testSidecarInjectorYAML); err != nil {
+		return err
+	}
+	content, err = ioutil.ReadFile(testSidecarInjectorYAML)
+	if err != nil {
+		return err
+	}
+	content, err = updateRevision(rev.Name, content)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(testSidecarInjectorYAML, content, 0600); err != nil {
+		return err
+	}
+	return util.KubeApply(k.Namespace, testSidecarInjectorYAML, k.KubeConfig)
+}
+
+// LabelNamespaceForRevision labels ns with istio.io/rev=rev, so the sidecar injector webhook for
+// that revision (and only that revision) performs injection in the namespace.
+func (k *KubeInfo) LabelNamespaceForRevision(ns, rev string) error {
+	_, err := util.Shell("kubectl label namespace %s istio.io/rev=%s --overwrite --kubeconfig=%s", ns, rev, k.KubeConfig)
+	return err
+}
+
+// IstioctlForRevision returns an *Istioctl configured to inject using rev's sidecar injector
+// config map, creating and caching it on first use.
+func (k *KubeInfo) IstioctlForRevision(rev string) (*Istioctl, error) {
+	if ictl, ok := k.revisionIstioctls[rev]; ok {
+		return ictl, nil
+	}
+	injectConfigMap := fmt.Sprintf("istio-sidecar-injector-%s", rev)
+	ictl, err := NewIstioctl(k.yamlDir, k.Namespace, *istioNamespace, injectConfigMap, k.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	k.revisionIstioctls[rev] = ictl
+	return ictl, nil
+}
+
 // DeployTiller deploys tiller in Istio mesh or returns error
 func (k *KubeInfo) DeployTiller() error {
 	// no need to deploy tiller when Istio is deployed using helm as Tiller is already deployed as part of it.
@@ -993,18 +1607,53 @@ spec:
 `
 )
 
-// Wait for Kubernetes to become active within kubernetesReadinessTimeout period
-// This operation only retreives the pods in the kube-system namespace
+// Wait for Kubernetes to become active within kubernetesReadinessTimeout period. This operation
+// only checks that the kube-system namespace has at least one pod.
 // (TODO) sdake: This may be insufficient for a complete readiness check of Kubernetes
 func (k *KubeInfo) waitForKubernetes() error {
 	log.Info("Waiting for Kubernetes to become responsive")
-	return retry.UntilSuccess(func() error {
-		_, err := k.KubeAccessor.GetPods("kube-system")
-		return err
-	}, retry.Delay(kubernetesReadinessInterval), retry.Timeout(kubernetesReadinessTimeout))
+	return k.waiter().WaitForAny(context.Background(), k.KubeConfig, podsGVR, "kube-system", kubernetesReadinessTimeout,
+		func(pods []*unstructured.Unstructured) (bool, error) {
+			return len(pods) > 0, nil
+		})
+}
+
+// WaitForClean waits for the test namespace (the istio-operator namespace instead, when
+// -use_operator is set) and the istio-galley validating webhook configuration, which Kubernetes'
+// GC controller removes asynchronously, to be fully torn down. Both waits share a single
+// readinessPollTimeout budget.
+func (k *KubeInfo) WaitForClean(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessPollTimeout)
+	defer cancel()
+
+	nsName := k.Namespace
+	if *useOperator {
+		nsName = "istio-operator"
+	}
+
+	if err := k.waiter().WaitForAbsence(ctx, k.KubeConfig, namespaceGVR, "", nsName, readinessPollTimeout); err != nil {
+		return fmt.Errorf("namespace %s deletion: %v", nsName, err)
+	}
+
+	return k.waiter().WaitForAbsence(ctx, k.KubeConfig, validatingWebhookGVR, "", "istio-galley", readinessPollTimeout)
 }
 
+// waitForValdiationWebhook waits for the istio-galley ValidatingWebhookConfiguration to be
+// registered with the API server, then probes it live: the galley webhook endpoint itself can
+// still be refusing connections for a few seconds after the object exists, and that can only be
+// observed by actually triggering an admission call, not by watching object state. So this first
+// waits on the informer-backed object existence (cheap, shared across tests), then falls back to
+// the original apply/delete-a-dummy-rule probe to confirm the endpoint is actually serving.
 func (k *KubeInfo) waitForValdiationWebhook() error {
+	ctx, cancel := context.WithTimeout(context.Background(), validationWebhookReadinessTimeout)
+	defer cancel()
+
+	if err := k.waiter().WaitFor(ctx, k.KubeConfig, validatingWebhookGVR, "", "istio-galley", validationWebhookReadinessTimeout,
+		func(*unstructured.Unstructured) (bool, error) {
+			return true, nil
+		}); err != nil {
+		return fmt.Errorf("istio-galley validatingwebhookconfiguration was never registered: %v", err)
+	}
 
 	add := fmt.Sprintf(`cat << EOF | kubectl --kubeconfig=%s apply -f -
 %s
@@ -1015,45 +1664,26 @@ EOF`, k.KubeConfig, dummyValidationRule)
 EOF`, k.KubeConfig, dummyValidationRule)
 
 	log.Info("Creating dummy rule to check for validation webhook readiness")
-	timeout := time.Now().Add(validationWebhookReadinessTimeout)
-	for {
-		if time.Now().After(timeout) {
-			return errors.New("timeout waiting for validation webhook readiness")
-		}
-
-		out, err := util.ShellSilent(add)
-		if err == nil && !strings.Contains(out, "connection refused") {
-			break
-		}
-
-		log.Errorf("Validation webhook not ready yet: %v %v", out, err)
-		time.Sleep(validationWebhookReadinessFreq)
-
+	var lastOut string
+	var lastErr error
+	err := wait.PollImmediateUntil(validationWebhookReadinessFreq, func() (bool, error) {
+		lastOut, lastErr = util.ShellSilent(add)
+		return lastErr == nil && !strings.Contains(lastOut, "connection refused"), nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("timeout waiting for validation webhook readiness: %v %v", lastOut, lastErr)
 	}
 	util.ShellSilent(remove) // nolint: errcheck
 	log.Info("Validation webhook is ready")
 	return nil
 }
 
+// waitForIstioOperator waits for the IstioOperator CR installed by the legacy static-manifest
+// operator path to converge every component to HEALTHY. See waitForIstioOperatorCR for the
+// equivalent used by the typed CR install path; both watch the same object through
+// KubeInfo.waiter() instead of polling "kubectl get iop -o yaml | grep HEALTHY".
 func (k *KubeInfo) waitForIstioOperator() error {
-
-	get := fmt.Sprintf(`kubectl --kubeconfig=%s get iop example-istiocontrolplane -n istio-operator -o yaml`, k.KubeConfig)
-	timeout := time.Now().Add(istioOperatorTimeout)
-	for {
-		if time.Now().After(timeout) {
-			return errors.New("timeout waiting for istio operator to deploy Istio")
-		}
-		out, err := util.ShellSilent(get)
-		if err == nil && strings.Contains(out, "HEALTHY") {
-			break
-		}
-
-		log.Warnf("istio-operator is still deploying Istio: %v", err)
-		time.Sleep(istioOperatorFreq)
-
-	}
-	log.Info("istio operator succeeds to deploy Istio")
-	return nil
+	return k.waitForIstioOperatorCR()
 }
 
 func (k *KubeInfo) deployCRDs(kubernetesCRD string) error {
@@ -1173,6 +1803,13 @@ func (k *KubeInfo) deployIstioWithHelm() error {
 		}
 	}
 
+	for _, rev := range k.Revisions {
+		if err := k.deployIstioForRevision(rev); err != nil {
+			log.Errorf("Deploying revision %s failed", rev.Name)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -1200,6 +1837,23 @@ func updateInjectVersion(version string, content []byte) []byte {
 	return r.ReplaceAllLiteral(content, versionLine)
 }
 
+// updateRevision tags istiod's Deployment name and labels, and its sidecar injector
+// MutatingWebhookConfiguration's revision selector, with revision. It errors rather than
+// returning content unchanged if the "istio.io/rev: " anchor isn't present: this manifest
+// predates revision-scoped webhooks, and silently no-op'ing here would leave the injector webhook
+// matching every namespace regardless of its istio.io/rev label, defeating canary isolation
+// between revisions with no indication anything went wrong.
+func updateRevision(revision string, content []byte) ([]byte, error) {
+	content = replacePattern(content, "name: istiod", fmt.Sprintf("name: istiod-%s", revision))
+	revisionLabel := []byte(fmt.Sprintf("istio.io/rev: %s", revision))
+	r := regexp.MustCompile("istio.io/rev: .*")
+	if !r.Match(content) {
+		return nil, fmt.Errorf("manifest has no istio.io/rev anchor to tag with revision %s", revision)
+	}
+	content = r.ReplaceAllLiteral(content, revisionLabel)
+	return content, nil
+}
+
 func (k *KubeInfo) generateSidecarInjector(src, dst string) error {
 	content, err := ioutil.ReadFile(src)
 	if err != nil {
@@ -1366,4 +2020,4 @@ func (k *KubeInfo) deployCNI() error {
 		log.Errorf("Kubeapply istio-cni failed")
 	}
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/jsonpb"
+	structpb "github.com/gogo/protobuf/types"
+	iopv1alpha1 "istio.io/api/operator/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/tests/util"
+	"istio.io/pkg/log"
+)
+
+const (
+	// operatorCRNamespace is the namespace the IstioOperator CR and its controller live in.
+	operatorCRNamespace = "istio-operator"
+	// operatorCRName is the name of the IstioOperator CR the e2e framework installs.
+	operatorCRName = "example-istiocontrolplane"
+)
+
+var legacyOperatorManifest = flag.Bool("use_legacy_operator_manifest", false,
+	"When -use_operator is set, apply the static istio-operator.yaml manifest "+
+		"instead of building and applying a typed IstioOperator CR. Kept "+
+		"for backward compatibility with the old regex-based install path.")
+
+// buildIstioOperatorCR constructs, in memory, the IstioOperator custom resource that drives the
+// typed operator install path. This carries every e2e flag (auth/mtls, per-component hub/tag,
+// outbound traffic policy, egress gateway, CNI, oneNamespace) as real fields on a typed spec,
+// rather than regex-splicing the static manifest the way generateIstio does.
+func (k *KubeInfo) buildIstioOperatorCR() *iopv1alpha1.IstioOperator {
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"istioNamespace": k.Namespace,
+			"oneNamespace":   !*clusterWide,
+			"mtls": map[string]interface{}{
+				"enabled": *authEnable,
+			},
+			"proxy": map[string]interface{}{
+				"outboundTrafficPolicy": map[string]interface{}{
+					"mode": *outboundTrafficPolicy,
+				},
+			},
+		},
+		"gateways": map[string]interface{}{
+			"istio-egressgateway": map[string]interface{}{
+				"enabled": *enableEgressGateway,
+			},
+		},
+		"istio_cni": map[string]interface{}{
+			"enabled": *useCNI,
+		},
+	}
+	setComponentImage(values, "pilot", *pilotHub, *pilotTag)
+	setComponentImage(values, "mixer", *mixerHub, *mixerTag)
+	setComponentImage(values, "galley", *galleyHub, *galleyTag)
+	setComponentImage(values, "citadel", *caHub, *caTag)
+	setComponentImage(values, "sidecarInjectorWebhook", *sidecarInjectorHub, *sidecarInjectorTag)
+
+	spec := &iopv1alpha1.IstioOperatorSpec{
+		Profile:   "default",
+		Namespace: k.Namespace,
+		Values:    toProtoStruct(values),
+	}
+	if *pilotHub != "" && *pilotTag != "" {
+		spec.Hub = *pilotHub
+		spec.Tag = *pilotTag
+	}
+
+	return &iopv1alpha1.IstioOperator{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "install.istio.io/v1alpha1",
+			Kind:       "IstioOperator",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      operatorCRName,
+			Namespace: operatorCRNamespace,
+		},
+		Spec: spec,
+	}
+}
+
+// setComponentImage records a per-component hub/tag override in values as
+// "<component>.hub"/"<component>.tag", keeping any other keys already set for that component.
+func setComponentImage(values map[string]interface{}, component, hub, tag string) {
+	if hub == "" || tag == "" {
+		return
+	}
+	comp, ok := values[component].(map[string]interface{})
+	if !ok {
+		comp = map[string]interface{}{}
+		values[component] = comp
+	}
+	comp["hub"] = hub
+	comp["tag"] = tag
+}
+
+// toProtoStruct converts a plain Go map into the proto Struct type IstioOperatorSpec.Values
+// expects, logging and returning nil on failure rather than panicking deep inside an Install call.
+func toProtoStruct(m map[string]interface{}) *structpb.Struct {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Errorf("failed to marshal operator values: %v", err)
+		return nil
+	}
+	st := &structpb.Struct{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(b), st); err != nil {
+		log.Errorf("failed to convert operator values to a proto Struct: %v", err)
+		return nil
+	}
+	return st
+}
+
+// deployIstioOperatorCR builds, applies, and waits on the IstioOperator CR described by
+// buildIstioOperatorCR, replacing the old apply-static-manifest-then-grep-for-HEALTHY path.
+func (k *KubeInfo) deployIstioOperatorCR() error {
+	return k.applyIstioOperatorCR(k.buildIstioOperatorCR())
+}
+
+// applyIstioOperatorCR marshals cr to YAML, applies it with kubectl, and waits for the
+// istio-operator controller to converge every component to HEALTHY.
+func (k *KubeInfo) applyIstioOperatorCR(cr *iopv1alpha1.IstioOperator) error {
+	content, err := yaml.Marshal(cr)
+	if err != nil {
+		return fmt.Errorf("failed to marshal IstioOperator CR: %v", err)
+	}
+	testIstioYaml := filepath.Join(k.TmpDir, "yaml", authOperatorInstallFile)
+	if err := ioutil.WriteFile(testIstioYaml, content, 0600); err != nil {
+		return fmt.Errorf("failed to write IstioOperator CR %s: %v", testIstioYaml, err)
+	}
+	if err := util.KubeApply(operatorCRNamespace, testIstioYaml, k.KubeConfig); err != nil {
+		return fmt.Errorf("failed to apply IstioOperator CR %s: %v", testIstioYaml, err)
+	}
+	return k.waitForIstioOperatorCR()
+}
+
+// waitForIstioOperatorCR watches the IstioOperator CR via KubeInfo.waiter() until every component
+// in its status reports HEALTHY, replacing the old "kubectl get -o yaml | grep HEALTHY" poll loop.
+func (k *KubeInfo) waitForIstioOperatorCR() error {
+	return k.waiter().WaitFor(context.Background(), k.KubeConfig, istioOperatorGVR, operatorCRNamespace, operatorCRName,
+		istioOperatorTimeout, func(obj *unstructured.Unstructured) (bool, error) {
+			return allComponentsHealthy(obj)
+		})
+}
+
+// allComponentsHealthy reports whether every component under obj's .status.status reports
+// HEALTHY. An empty or missing status means the controller hasn't reported anything yet, which
+// isn't healthy.
+func allComponentsHealthy(obj *unstructured.Unstructured) (bool, error) {
+	status, found, err := unstructured.NestedMap(obj.Object, "status", "status")
+	if err != nil {
+		return false, fmt.Errorf("malformed IstioOperator status: %v", err)
+	}
+	if !found || len(status) == 0 {
+		return false, nil
+	}
+	for name, raw := range status {
+		component, ok := raw.(map[string]interface{})
+		if !ok || component["status"] != "HEALTHY" {
+			log.Infof("component %s not yet healthy: %v", name, raw)
+			return false, nil
+		}
+	}
+	return true, nil
+}